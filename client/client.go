@@ -0,0 +1,74 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/genuinetools/img/snapshots/fuse"
+	"github.com/genuinetools/img/solver/mounts"
+	"github.com/genuinetools/img/types"
+	"github.com/moby/buildkit/cache"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/source"
+	"github.com/moby/buildkit/worker/base"
+)
+
+// Client holds the state for talking to the embedded buildkit worker: the
+// on-disk root, the configured snapshotter backend, and the pieces that
+// createWorkerOpt wires up the first time it runs.
+type Client struct {
+	root    string
+	backend types.Backend
+
+	sessionManagerOnce sync.Once
+	sessionManager     *session.Manager
+	sessionManagerErr  error
+	fuseserver         *fuse.Server
+
+	cacheManager  cache.Manager
+	sourceManager *source.Manager
+	mountManager  *mounts.MountManager
+
+	workerOnce sync.Once
+	workerOpt  base.WorkerOpt
+	workerErr  error
+
+	// gcMaxSize and gcMaxKeepDuration configure the cache manager's
+	// GCPolicy; they come from the --cache-max-size/--cache-max-age flags
+	// on "img build" and "img prune".
+	gcMaxSize         int64
+	gcMaxKeepDuration time.Duration
+}
+
+// New returns a new Client rooted at root, using the given snapshotter
+// backend and GC policy bounds.
+func New(root string, backend types.Backend, gcMaxSize int64, gcMaxKeepDuration time.Duration) *Client {
+	return &Client{
+		root:              root,
+		backend:           backend,
+		gcMaxSize:         gcMaxSize,
+		gcMaxKeepDuration: gcMaxKeepDuration,
+	}
+}
+
+// getSessionManager returns the client's session manager, creating it on
+// first use. A failed first attempt is cached alongside the (nil) manager,
+// so a later call reports the same error instead of silently retrying into
+// a nil manager.
+func (c *Client) getSessionManager() (*session.Manager, error) {
+	c.sessionManagerOnce.Do(func() {
+		c.sessionManager, c.sessionManagerErr = session.NewManager()
+	})
+	return c.sessionManager, c.sessionManagerErr
+}
+
+// Worker wires up (or returns the already wired up) base.WorkerOpt for this
+// client: caches, sources, exporters, and cache mounts. It is the entry
+// point the "build" and "prune" CLI commands use to apply the configured
+// backend and GCPolicy flags before doing anything else.
+func (c *Client) Worker() (base.WorkerOpt, error) {
+	c.workerOnce.Do(func() {
+		c.workerOpt, c.workerErr = c.createWorkerOpt()
+	})
+	return c.workerOpt, c.workerErr
+}
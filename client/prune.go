@@ -0,0 +1,47 @@
+package client
+
+import (
+	"context"
+
+	bkclient "github.com/moby/buildkit/client"
+	"golang.org/x/sync/errgroup"
+)
+
+// Prune removes build cache records that are not in use. The configured
+// GCPolicy (MaxSize/MaxKeepDuration) is used as the floor: records younger
+// than MaxKeepDuration or needed to stay under MaxSize are kept. Each
+// reclaimed record is reported to out as it is removed, and the total number
+// of bytes reclaimed is returned.
+func (c *Client) Prune(ctx context.Context, out func(bkclient.UsageInfo)) (int64, error) {
+	if _, err := c.Worker(); err != nil {
+		return 0, err
+	}
+	cm := c.cacheManager
+
+	ch := make(chan bkclient.UsageInfo)
+	eg, ctx := errgroup.WithContext(ctx)
+
+	var reclaimed int64
+	eg.Go(func() error {
+		defer close(ch)
+		return cm.Prune(ctx, ch, bkclient.PruneInfo{
+			KeepBytes:    c.gcMaxSize,
+			KeepDuration: c.gcMaxKeepDuration,
+		})
+	})
+	eg.Go(func() error {
+		for ui := range ch {
+			reclaimed += ui.Size
+			if out != nil {
+				out(ui)
+			}
+		}
+		return nil
+	})
+
+	if err := eg.Wait(); err != nil {
+		return reclaimed, err
+	}
+
+	return reclaimed, nil
+}
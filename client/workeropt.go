@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"path/filepath"
-	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/containerd/containerd/content/local"
@@ -16,13 +15,21 @@ import (
 	"github.com/containerd/containerd/snapshots/overlay"
 	"github.com/genuinetools/img/executor/runc"
 	"github.com/genuinetools/img/snapshots/fuse"
+	"github.com/genuinetools/img/snapshots/stargz"
+	"github.com/genuinetools/img/solver/mounts"
+	containerimagesource "github.com/genuinetools/img/source/containerimage"
+	localsource "github.com/genuinetools/img/source/local"
 	"github.com/genuinetools/img/types"
+	"github.com/moby/buildkit/cache"
 	"github.com/moby/buildkit/cache/metadata"
+	"github.com/moby/buildkit/exporter"
+	localexporter "github.com/moby/buildkit/exporter/local"
+	tarexporter "github.com/moby/buildkit/exporter/tar"
 	containerdsnapshot "github.com/moby/buildkit/snapshot/containerd"
-	"github.com/moby/buildkit/util/throttle"
+	"github.com/moby/buildkit/source"
+	"github.com/moby/buildkit/util/leaseutil"
 	"github.com/moby/buildkit/worker/base"
 	"github.com/opencontainers/runc/libcontainer/system"
-	"github.com/sirupsen/logrus"
 )
 
 // createWorkerOpt creates a base.WorkerOpt to be used for a new worker.
@@ -54,6 +61,10 @@ func (c *Client) createWorkerOpt() (opt base.WorkerOpt, err error) {
 		s, err = native.NewSnapshotter(filepath.Join(c.root, "snapshots"))
 	case types.OverlayFSBackend:
 		s, err = overlay.NewSnapshotter(filepath.Join(c.root, "snapshots"))
+	case types.StargzBackend:
+		// Mutually exclusive with FUSEBackend: both manage their own FUSE
+		// mounts under the same daemon root.
+		s, err = stargz.NewSnapshotter(filepath.Join(c.root, "snapshots"))
 	default:
 		return opt, fmt.Errorf("%s is not a valid snapshots backend", c.backend)
 	}
@@ -84,19 +95,11 @@ func (c *Client) createWorkerOpt() (opt base.WorkerOpt, err error) {
 	// Create the image store.
 	imageStore := ctdmetadata.NewImageStore(mdb)
 
-	// Create the garbage collector.
-	throttledGC := throttle.Throttle(time.Second, func() {
-		if _, err := mdb.GarbageCollect(context.TODO()); err != nil {
-			logrus.Errorf("GC error: %+v", err)
-		}
-	})
-
-	gc := func(ctx context.Context) error {
-		throttledGC()
-		return nil
-	}
+	// Create the lease manager so refs are pinned against containerd GC
+	// instead of relying on an unbounded full-DB sweep.
+	lm := leaseutil.WithNamespace(ctdmetadata.NewLeaseManager(mdb), "buildkit")
 
-	contentStore = containerdsnapshot.NewContentStore(mdb.ContentStore(), "buildkit", gc)
+	contentStore = containerdsnapshot.NewContentStore(mdb.ContentStore(), lm, "buildkit")
 
 	id, err := base.ID(c.root)
 	if err != nil {
@@ -111,11 +114,78 @@ func (c *Client) createWorkerOpt() (opt base.WorkerOpt, err error) {
 		SessionManager: sm,
 		MetadataStore:  md,
 		Executor:       exe,
-		Snapshotter:    containerdsnapshot.NewSnapshotter(mdb.Snapshotter(c.backend), contentStore, md, "buildkit", gc),
+		Snapshotter:    containerdsnapshot.NewSnapshotter(mdb.Snapshotter(c.backend), contentStore, lm, "buildkit"),
 		ContentStore:   contentStore,
 		Applier:        apply.NewFileSystemApplier(contentStore),
 		Differ:         walking.NewWalkingDiff(contentStore),
 		ImageStore:     imageStore,
+		LeaseManager:   lm,
+		GCPolicy: cache.GCPolicy{
+			MaxSize:         c.gcMaxSize,
+			MaxKeepDuration: c.gcMaxKeepDuration,
+		},
+	}
+
+	c.cacheManager, err = cache.NewManager(cache.ManagerOpt{
+		Snapshotter:   opt.Snapshotter,
+		MetadataStore: opt.MetadataStore,
+		ContentStore:  opt.ContentStore,
+		LeaseManager:  opt.LeaseManager,
+	})
+	if err != nil {
+		return opt, fmt.Errorf("creating cache manager failed: %v", err)
+	}
+
+	// Cache mounts (RUN --mount=type=cache) are kept separately from the
+	// source cache so they persist by id across builds and daemon restarts.
+	c.mountManager = mounts.NewMountManager(c.cacheManager, md)
+
+	// Register the sources that can be resolved against this worker's
+	// cache: local build context (over the session) and docker images
+	// (pulled layer-by-layer so layers dedupe by blob digest).
+	c.sourceManager = source.NewManager()
+
+	ls, err := localsource.NewSource(localsource.Opt{
+		SessionManager: sm,
+		CacheAccessor:  c.cacheManager,
+		MetadataStore:  md,
+	})
+	if err != nil {
+		return opt, err
+	}
+	c.sourceManager.Register(ls)
+
+	is, err := containerimagesource.NewSource(containerimagesource.SourceOpt{
+		SessionManager: sm,
+		CacheAccessor:  c.cacheManager,
+		ContentStore:   opt.ContentStore,
+	})
+	if err != nil {
+		return opt, err
+	}
+	c.sourceManager.Register(is)
+
+	// Register the exporters that let a build result be extracted without
+	// going through an image: a tar of the rootfs, or a plain directory
+	// copied to the caller over the session.
+	texp, err := tarexporter.New(tarexporter.Opt{
+		SessionManager: sm,
+		ContentStore:   opt.ContentStore,
+	})
+	if err != nil {
+		return opt, err
+	}
+
+	lexp, err := localexporter.New(localexporter.Opt{
+		SessionManager: sm,
+	})
+	if err != nil {
+		return opt, err
+	}
+
+	opt.Exporters = map[string]exporter.Exporter{
+		"tar":   texp,
+		"local": lexp,
 	}
 
 	return opt, err
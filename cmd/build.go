@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/genuinetools/img/client"
+	"github.com/genuinetools/img/types"
+)
+
+const buildHelp = `Build an image from a Dockerfile.`
+
+// buildCommand implements the "build" command.
+type buildCommand struct {
+	backend         string
+	stateDir        string
+	cacheMaxSize    int64
+	cacheMaxKeepAge time.Duration
+	output          string
+}
+
+// NewBuildCommand returns a new build command.
+func NewBuildCommand() *buildCommand {
+	return &buildCommand{}
+}
+
+func (cmd *buildCommand) Name() string      { return "build" }
+func (cmd *buildCommand) Args() string      { return "[OPTIONS] PATH" }
+func (cmd *buildCommand) ShortHelp() string { return "Build an image from a Dockerfile." }
+func (cmd *buildCommand) LongHelp() string  { return buildHelp }
+
+// Register registers the flags for the build command.
+func (cmd *buildCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.stateDir, "state", "/tmp/img", "directory to hold the build state")
+	fs.StringVar(&cmd.backend, "backend", string(types.OverlayFSBackend), backendUsage)
+	fs.Int64Var(&cmd.cacheMaxSize, "cache-max-size", 0, "size bound in bytes for the worker's cache GC policy (0 means unbounded)")
+	fs.DurationVar(&cmd.cacheMaxKeepAge, "cache-max-age", 0, "age bound for the worker's cache GC policy (0 means unbounded)")
+	fs.StringVar(&cmd.output, "output", "", `export the build result, e.g. "type=tar,dest=-" or "type=local,dest=./out"`)
+}
+
+// Run runs the build command.
+//
+// The Dockerfile frontend and LLB solver that would actually execute a
+// build are not part of this tree, so this wires the configured backend,
+// GCPolicy, and output flags through to the worker and stops there.
+func (cmd *buildCommand) Run(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("must pass a path to the build context")
+	}
+
+	c := client.New(cmd.stateDir, types.Backend(cmd.backend), cmd.cacheMaxSize, cmd.cacheMaxKeepAge)
+
+	opt, err := c.Worker()
+	if err != nil {
+		return fmt.Errorf("initializing worker failed: %v", err)
+	}
+
+	if cmd.output != "" {
+		exporterType, attrs, err := parseOutput(cmd.output)
+		if err != nil {
+			return err
+		}
+		if _, ok := opt.Exporters[exporterType]; !ok {
+			return fmt.Errorf("no exporter registered for --output type %q", exporterType)
+		}
+		if attrs["dest"] == "" {
+			return fmt.Errorf("--output type=%s requires dest, e.g. --output type=%s,dest=./out", exporterType, exporterType)
+		}
+	}
+
+	return fmt.Errorf("build: not implemented in this tree")
+}
+
+// parseOutput parses a buildkit-style --output value, e.g.
+// "type=tar,dest=-", into the exporter type and its remaining attrs.
+func parseOutput(output string) (string, map[string]string, error) {
+	attrs := map[string]string{}
+	for _, field := range strings.Split(output, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return "", nil, fmt.Errorf("invalid --output field %q: expected key=value", field)
+		}
+		attrs[parts[0]] = parts[1]
+	}
+
+	exporterType, ok := attrs["type"]
+	if !ok {
+		return "", nil, fmt.Errorf(`--output requires a type, e.g. --output "type=tar,dest=-"`)
+	}
+	delete(attrs, "type")
+
+	return exporterType, attrs, nil
+}
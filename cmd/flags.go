@@ -0,0 +1,4 @@
+package cmd
+
+// backendUsage is shared by the build and prune commands' --backend flag.
+const backendUsage = "backend for snapshots (fuse, native, overlay, or stargz; stargz is mutually exclusive with fuse for a given --state dir)"
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/genuinetools/img/client"
+	"github.com/genuinetools/img/types"
+	bkclient "github.com/moby/buildkit/client"
+)
+
+const pruneHelp = `Remove build cache that is not in use, optionally bounded by size or age.`
+
+// pruneCommand implements the "prune" command.
+type pruneCommand struct {
+	backend         string
+	stateDir        string
+	cacheMaxSize    int64
+	cacheMaxKeepAge time.Duration
+}
+
+// NewPruneCommand returns a new prune command.
+func NewPruneCommand() *pruneCommand {
+	return &pruneCommand{}
+}
+
+func (cmd *pruneCommand) Name() string      { return "prune" }
+func (cmd *pruneCommand) Args() string      { return "[OPTIONS]" }
+func (cmd *pruneCommand) ShortHelp() string { return "Remove build cache." }
+func (cmd *pruneCommand) LongHelp() string  { return pruneHelp }
+
+// Register registers the flags for the prune command.
+func (cmd *pruneCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.stateDir, "state", "/tmp/img", "directory to hold the build state")
+	fs.StringVar(&cmd.backend, "backend", string(types.OverlayFSBackend), backendUsage)
+	fs.Int64Var(&cmd.cacheMaxSize, "cache-max-size", 0, "keep the cache under this many bytes, reclaiming the least recently used records first (0 means unbounded)")
+	fs.DurationVar(&cmd.cacheMaxKeepAge, "cache-max-age", 0, "reclaim cache records older than this (0 means unbounded)")
+}
+
+// Run runs the prune command.
+func (cmd *pruneCommand) Run(ctx context.Context, args []string) error {
+	c := client.New(cmd.stateDir, types.Backend(cmd.backend), cmd.cacheMaxSize, cmd.cacheMaxKeepAge)
+
+	reclaimed, err := c.Prune(ctx, func(ui bkclient.UsageInfo) {
+		fmt.Printf("reclaimed %d bytes: %s\n", ui.Size, ui.ID)
+	})
+	if err != nil {
+		return fmt.Errorf("prune failed: %v", err)
+	}
+
+	fmt.Printf("total: reclaimed %d bytes\n", reclaimed)
+
+	return nil
+}
@@ -0,0 +1,16 @@
+// Package stargz wraps the containerd stargz-snapshotter so that img can
+// mount eStargz image layers on demand via FUSE instead of fully unpacking
+// them first.
+package stargz
+
+import (
+	ctdsnapshot "github.com/containerd/containerd/snapshots"
+	"github.com/containerd/stargz-snapshotter/snapshot"
+)
+
+// NewSnapshotter returns a stargz-backed snapshotter rooted at root. Layers
+// that carry eStargz TOC annotations are mounted lazily; everything else
+// falls back to a normal extract-on-pull.
+func NewSnapshotter(root string) (ctdsnapshot.Snapshotter, error) {
+	return snapshot.NewFileSystemSnapshotter(root)
+}
@@ -0,0 +1,224 @@
+package mounts
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/boltdb/bolt"
+	"github.com/moby/buildkit/cache"
+	"github.com/moby/buildkit/cache/metadata"
+	"github.com/sirupsen/logrus"
+)
+
+// CacheSharingMode defines how concurrent RUN --mount=type=cache
+// invocations using the same id share (or don't share) the underlying
+// mutable ref.
+type CacheSharingMode int
+
+const (
+	// CacheMountShared lets concurrent users mount the same ref at once.
+	CacheMountShared CacheSharingMode = iota
+	// CacheMountPrivate gives every concurrent user its own ref: a ref
+	// previously indexed under the same key is reused when no one else has
+	// it open, otherwise a fresh one is created and indexed for later reuse.
+	CacheMountPrivate
+	// CacheMountLocked serializes access to the ref behind a per-id mutex.
+	CacheMountLocked
+)
+
+const keyCacheDir = "cache-dir"
+
+// MountManager hands out persistent cache.MutableRefs for
+// RUN --mount=type=cache,id=... mounts, keyed by id (and, for mounts scoped
+// to a particular parent ref, by that parent's ID) so that repeated builds
+// reuse the same cache directory instead of starting empty. Refs are
+// indexed in the metadata store so they are found again across daemon
+// restarts, and are released back to the cache manager as soon as the
+// caller is done with them, so they are otherwise ordinary retained refs
+// that the cache manager's GC policy can reclaim.
+type MountManager struct {
+	cm cache.Accessor
+	md *metadata.Store
+
+	mu     sync.Mutex
+	shares map[string]*cacheRefShare
+	locks  map[string]*sync.Mutex
+}
+
+// NewMountManager returns a new MountManager backed by cm for cache refs and
+// md for the shared-key index.
+func NewMountManager(cm cache.Accessor, md *metadata.Store) *MountManager {
+	return &MountManager{
+		cm:     cm,
+		md:     md,
+		shares: map[string]*cacheRefShare{},
+		locks:  map[string]*sync.Mutex{},
+	}
+}
+
+// cacheRefShare is the single ref backing all concurrent CacheMountShared
+// users of a given key. ready is locked by whichever goroutine creates the
+// share, and only unlocked once ref/err have been populated, so that a
+// concurrent getShare for the same brand-new key blocks instead of reading
+// the zero value.
+type cacheRefShare struct {
+	ready    sync.Mutex
+	ref      cache.MutableRef
+	err      error
+	refCount int
+}
+
+// GetRef returns the mutable ref for the cache mount "id", honoring the
+// given sharing mode. The returned release func must be called once the
+// caller is done with the mount.
+func (mm *MountManager) GetRef(ctx context.Context, id, parentRefID string, sharing CacheSharingMode) (cache.MutableRef, func(), error) {
+	key := keyCacheDir + ":" + id
+	if parentRefID != "" {
+		key += ":" + parentRefID
+	}
+
+	switch sharing {
+	case CacheMountShared:
+		share, err := mm.getShare(ctx, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		ref := share.ref
+		return ref, func() { mm.releaseShare(key) }, nil
+
+	case CacheMountLocked:
+		l := mm.lockFor(key)
+		l.Lock()
+		ref, err := mm.getOrCreate(ctx, key)
+		if err != nil {
+			l.Unlock()
+			return nil, nil, err
+		}
+		return ref, func() {
+			ref.Release(context.TODO())
+			l.Unlock()
+		}, nil
+
+	default: // CacheMountPrivate
+		ref, err := mm.getOrCreate(ctx, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return ref, func() { ref.Release(context.TODO()) }, nil
+	}
+}
+
+// getShare returns the cacheRefShare for key, creating (and fully
+// populating) it if this is the first concurrent user. Callers that arrive
+// while creation is still in flight block on share.ready until it finishes,
+// so they never observe a nil share.ref.
+func (mm *MountManager) getShare(ctx context.Context, key string) (*cacheRefShare, error) {
+	mm.mu.Lock()
+	share, ok := mm.shares[key]
+	if ok {
+		share.refCount++
+		mm.mu.Unlock()
+	} else {
+		share = &cacheRefShare{refCount: 1}
+		share.ready.Lock()
+		mm.shares[key] = share
+		mm.mu.Unlock()
+
+		share.ref, share.err = mm.getOrCreate(ctx, key)
+		share.ready.Unlock()
+
+		if share.err != nil {
+			mm.mu.Lock()
+			delete(mm.shares, key)
+			mm.mu.Unlock()
+			return nil, share.err
+		}
+		return share, nil
+	}
+
+	share.ready.Lock()
+	err := share.err
+	share.ready.Unlock()
+	if err != nil {
+		mm.releaseShare(key)
+		return nil, err
+	}
+	return share, nil
+}
+
+func (mm *MountManager) releaseShare(key string) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	share, ok := mm.shares[key]
+	if !ok {
+		return
+	}
+	share.refCount--
+	if share.refCount == 0 {
+		delete(mm.shares, key)
+		if share.ref != nil {
+			share.ref.Release(context.TODO())
+		}
+	}
+}
+
+func (mm *MountManager) lockFor(key string) *sync.Mutex {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	l, ok := mm.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		mm.locks[key] = l
+	}
+	return l
+}
+
+// getOrCreate looks up a retained ref previously indexed under key, or
+// creates and indexes a new one. It is used by all three sharing modes.
+// GetMutable fails for a ref that is already open, so a ref held by another
+// user is skipped; for CacheMountPrivate that means concurrent users of the
+// same key each end up with a different ref.
+func (mm *MountManager) getOrCreate(ctx context.Context, key string) (cache.MutableRef, error) {
+	sis, err := mm.md.Search(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, si := range sis {
+		if ref, err := mm.cm.GetMutable(ctx, si.ID()); err == nil {
+			logrus.Debugf("reusing ref for cache dir %s: %s", key, ref.ID())
+			return ref, nil
+		}
+	}
+
+	return mm.createRef(ctx, key)
+}
+
+// createRef always allocates a brand new ref and indexes it under key,
+// without checking for an existing one.
+func (mm *MountManager) createRef(ctx context.Context, key string) (cache.MutableRef, error) {
+	ref, err := mm.cm.New(ctx, nil, cache.CachePolicyRetain, cache.WithDescription(fmt.Sprintf("cache mount %s", key)))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mm.index(ref, key); err != nil {
+		ref.Release(context.TODO())
+		return nil, err
+	}
+	logrus.Debugf("new ref for cache dir %s: %s", key, ref.ID())
+
+	return ref, nil
+}
+
+func (mm *MountManager) index(ref cache.MutableRef, key string) error {
+	si, _ := mm.md.Get(ref.ID())
+	v, err := metadata.NewValue(key)
+	if err != nil {
+		return err
+	}
+	v.Index = key
+	return si.Update(func(b *bolt.Bucket) error {
+		return si.SetValue(b, key, v)
+	})
+}
@@ -0,0 +1,197 @@
+package containerimage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/moby/buildkit/cache"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/source"
+	"github.com/moby/buildkit/util/contentutil"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// SourceOpt contains the options for the containerimage (docker-image)
+// source. Unlike a snapshotter-chain pull, this source commits one
+// cache.ImmutableRef per layer so that layers can be shared and deduped by
+// blob digest across images and across snapshotter backends.
+type SourceOpt struct {
+	SessionManager *session.Manager
+	CacheAccessor  cache.Accessor
+	ContentStore   content.Store
+}
+
+// NewSource returns a new source object.
+func NewSource(opt SourceOpt) (source.Source, error) {
+	return &imageSource{opt}, nil
+}
+
+type imageSource struct {
+	SourceOpt
+}
+
+// labelStargzReference marks a layer descriptor as eStargz-capable; the
+// stargz snapshotter backend recognizes it and mounts the layer on demand
+// instead of requiring a full unpack.
+const labelStargzReference = "containerd.io/snapshot/remote/stargz.reference"
+
+func (is *imageSource) ID() string {
+	return source.DockerImageScheme
+}
+
+func (is *imageSource) Resolve(ctx context.Context, id source.Identifier) (source.SourceInstance, error) {
+	imageIdentifier, ok := id.(*source.ImageIdentifier)
+	if !ok {
+		return nil, errors.Errorf("invalid image identifier %v", id)
+	}
+
+	return &puller{
+		is:       is,
+		src:      *imageIdentifier,
+		resolver: docker.NewResolver(docker.ResolverOptions{}),
+	}, nil
+}
+
+// puller resolves an image reference and, on Snapshot, pulls it one layer at
+// a time via CacheAccessor.GetByBlob, chaining each layer to its parent.
+type puller struct {
+	is       *imageSource
+	src      source.ImageIdentifier
+	resolver remotes.Resolver
+
+	resolveOnce sync.Once
+	resolveErr  error
+	ref         string
+	layers      []ocispec.Descriptor
+	diffIDs     []digest.Digest
+}
+
+// resolve fetches the image manifest and config (but no layers) so that the
+// layer and diffID chains are known. It is safe to call more than once.
+func (p *puller) resolve(ctx context.Context) error {
+	p.resolveOnce.Do(func() {
+		ref, desc, err := p.resolver.Resolve(ctx, p.src.Reference.String())
+		if err != nil {
+			p.resolveErr = errors.Wrapf(err, "failed to resolve %s", p.src.Reference.String())
+			return
+		}
+
+		fetcher, err := p.resolver.Fetcher(ctx, ref)
+		if err != nil {
+			p.resolveErr = err
+			return
+		}
+
+		manifest, err := images.Manifest(ctx, contentutil.FromFetcher(fetcher), desc, platforms.Default())
+		if err != nil {
+			p.resolveErr = errors.Wrapf(err, "failed to read manifest for %s", p.src.Reference.String())
+			return
+		}
+
+		configDt, err := content.ReadBlob(ctx, contentutil.FromFetcher(fetcher), manifest.Config)
+		if err != nil {
+			p.resolveErr = errors.Wrapf(err, "failed to read image config for %s", p.src.Reference.String())
+			return
+		}
+
+		var config struct {
+			RootFS struct {
+				DiffIDs []digest.Digest `json:"diff_ids"`
+			} `json:"rootfs"`
+		}
+		if err := json.Unmarshal(configDt, &config); err != nil {
+			p.resolveErr = err
+			return
+		}
+		if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+			p.resolveErr = errors.Errorf("invalid image config for %s: %d diff ids for %d layers", p.src.Reference.String(), len(config.RootFS.DiffIDs), len(manifest.Layers))
+			return
+		}
+
+		p.ref = ref
+		p.layers = manifest.Layers
+		p.diffIDs = config.RootFS.DiffIDs
+	})
+	return p.resolveErr
+}
+
+// CacheKey returns a key derived from the chain of layer diffIDs, so that
+// identical layer stacks resolve to the same key regardless of which tag or
+// registry they were pulled from.
+func (p *puller) CacheKey(ctx context.Context) (string, error) {
+	if err := p.resolve(ctx); err != nil {
+		return "", err
+	}
+
+	var chainID digest.Digest
+	for _, diffID := range p.diffIDs {
+		if chainID == "" {
+			chainID = diffID
+			continue
+		}
+		chainID = digest.FromBytes([]byte(chainID + " " + diffID))
+	}
+
+	return chainID.String(), nil
+}
+
+// Snapshot builds (or reuses) one cache.ImmutableRef per layer, passing
+// every layer that is not marked for lazy stargz mounting through the fetch
+// handler before GetByBlob, and chains each ref to its parent so that
+// identical layer stacks share storage.
+func (p *puller) Snapshot(ctx context.Context) (cache.ImmutableRef, error) {
+	if err := p.resolve(ctx); err != nil {
+		return nil, err
+	}
+
+	fetcher, err := p.resolver.Fetcher(ctx, p.ref)
+	if err != nil {
+		return nil, err
+	}
+	fetch := remotes.FetchHandler(p.is.ContentStore, fetcher)
+
+	var parent cache.ImmutableRef
+	for i, layerDesc := range p.layers {
+		desc := layerDesc
+		if desc.Annotations == nil {
+			desc.Annotations = map[string]string{}
+		}
+		desc.Annotations["containerd.io/uncompressed"] = p.diffIDs[i].String()
+
+		if _, ok := desc.Annotations[labelStargzReference]; ok {
+			// The stargz snapshotter mounts this layer lazily via FUSE, so
+			// skip pulling the full blob into the content store.
+			logrus.Debugf("skipping fetch of lazy (stargz) layer %d/%d: %s", i+1, len(p.layers), desc.Digest)
+		} else if _, err := fetch(ctx, desc); err != nil {
+			if parent != nil {
+				parent.Release(context.TODO())
+			}
+			return nil, errors.Wrapf(err, "failed to fetch layer %s", desc.Digest)
+		}
+
+		ref, err := p.is.CacheAccessor.GetByBlob(ctx, desc, parent, cache.WithDescription(fmt.Sprintf("pulled from %s", p.src.Reference.String())))
+		if err != nil {
+			if parent != nil {
+				parent.Release(context.TODO())
+			}
+			return nil, errors.Wrapf(err, "failed to get ref for layer %s", desc.Digest)
+		}
+		if parent != nil {
+			parent.Release(context.TODO())
+		}
+		parent = ref
+		logrus.Debugf("pulled layer %d/%d: %s", i+1, len(p.layers), desc.Digest)
+	}
+
+	return parent, nil
+}
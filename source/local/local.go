@@ -11,6 +11,7 @@ import (
 	"github.com/moby/buildkit/cache/contenthash"
 	"github.com/moby/buildkit/cache/metadata"
 	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/filesync"
 	"github.com/moby/buildkit/snapshot"
 	"github.com/moby/buildkit/source"
 	digest "github.com/opencontainers/go-digest"
@@ -23,14 +24,21 @@ const keySharedKey = "local.sharedKey"
 
 // Opt contains the options for the local source.
 type Opt struct {
-	CacheAccessor cache.Accessor
-	MetadataStore *metadata.Store
-	LocalDirs     map[string]string
+	SessionManager *session.Manager
+	CacheAccessor  cache.Accessor
+	MetadataStore  *metadata.Store
+	// LocalDirs optionally maps a source name to a directory that is
+	// directly accessible on the daemon's filesystem. A name listed here is
+	// always copied from that directory, even when the client also serves it
+	// over the session; the session's filesync service is used only for
+	// names that are not listed.
+	LocalDirs map[string]string
 }
 
 // NewSource returns a new source object.
 func NewSource(opt Opt) (source.Source, error) {
 	ls := &localSource{
+		sm: opt.SessionManager,
 		cm: opt.CacheAccessor,
 		md: opt.MetadataStore,
 		ld: opt.LocalDirs,
@@ -39,6 +47,7 @@ func NewSource(opt Opt) (source.Source, error) {
 }
 
 type localSource struct {
+	sm *session.Manager
 	cm cache.Accessor
 	md *metadata.Store
 	ld map[string]string
@@ -145,8 +154,27 @@ func (ls *localSourceHandler) Snapshot(ctx context.Context) (out cache.Immutable
 		return nil, err
 	}
 
-	if err := fsutils.CopyDir(ls.ld[ls.src.Name], dest, ls.src, &cacheUpdater{cc}); err != nil {
-		return nil, err
+	if localDir, ok := ls.ld[ls.src.Name]; ok {
+		// The directory is already accessible on the daemon's filesystem, so
+		// just copy it directly instead of going through the session.
+		if err := fsutils.CopyDir(localDir, dest, ls.src, &cacheUpdater{cc}); err != nil {
+			return nil, err
+		}
+	} else {
+		caller, err := ls.sm.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := filesync.FSSync(ctx, caller, filesync.FSSendRequestOpt{
+			Name:            ls.src.Name,
+			IncludePatterns: ls.src.IncludePatterns,
+			ExcludePatterns: ls.src.ExcludePatterns,
+			DestDir:         dest,
+			CacheUpdater:    &cacheUpdater{cc},
+		}); err != nil {
+			return nil, err
+		}
 	}
 
 	if err := lm.Unmount(); err != nil {
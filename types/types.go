@@ -0,0 +1,21 @@
+// Package types holds small shared types used across the client and
+// snapshotter backends.
+package types
+
+// Backend identifies which snapshotter implementation the daemon root uses
+// to store and assemble image layers. It is a plain string so CLI flag
+// values can be used directly without conversion.
+type Backend = string
+
+const (
+	// FUSEBackend uses fuse-overlayfs and requires no special privileges.
+	FUSEBackend Backend = "fuse"
+	// NaiveBackend uses containerd's naive (copy-on-write-less) snapshotter.
+	NaiveBackend Backend = "native"
+	// OverlayFSBackend uses the kernel's overlayfs snapshotter.
+	OverlayFSBackend Backend = "overlay"
+	// StargzBackend lazily mounts eStargz layers via FUSE instead of fully
+	// unpacking them. It manages its own FUSE mounts, so it is mutually
+	// exclusive with FUSEBackend for a given daemon root.
+	StargzBackend Backend = "stargz"
+)